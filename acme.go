@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/ovh/go-ovh/ovh"
+	"github.com/rs/zerolog/log"
+)
+
+// acmeUser implements lego's registration.User interface so the ACME client can
+// register an account against the configured CA directory.
+type acmeUser struct {
+	Email        string
+	Registration *registration.Resource
+	key          crypto.PrivateKey
+}
+
+func (u *acmeUser) GetEmail() string { return u.Email }
+func (u *acmeUser) GetRegistration() *registration.Resource { return u.Registration }
+func (u *acmeUser) GetPrivateKey() crypto.PrivateKey { return u.key }
+
+// OVHDNSProvider implements lego's challenge.Provider contract by creating and
+// deleting TXT records at "_acme-challenge.<subdomain>" in a configured OVH zone.
+// It satisfies the DNS-01 challenge used by go-acme/lego without depending on
+// lego's own OVH provider, since it reuses the OVH client and helpers already
+// wired up for dynamic-DNS updates.
+type OVHDNSProvider struct {
+	client *ovh.Client
+	zone   string
+
+	mu        sync.Mutex
+	recordIDs map[string]int
+}
+
+// NewOVHDNSProvider returns an OVHDNSProvider backed by the given OVH API client,
+// managing TXT records within zone.
+func NewOVHDNSProvider(client *ovh.Client, zone string) *OVHDNSProvider {
+	return &OVHDNSProvider{
+		client:    client,
+		zone:      zone,
+		recordIDs: make(map[string]int),
+	}
+}
+
+// Present creates the TXT record required to fulfil the ACME DNS-01 challenge for
+// fqdn, as expected by the go-acme/lego challenge.Provider interface.
+func (p *OVHDNSProvider) Present(fqdn, token, keyAuth string) error {
+	challengeFQDN, value := dns01.GetRecord(fqdn, keyAuth)
+	subdomain := p.acmeSubdomain(challengeFQDN)
+
+	rec := NewRecord("TXT", subdomain, value, 60)
+	created, err := PostNewRecord(p.client, p.zone, *rec)
+	if err != nil {
+		return fmt.Errorf("acme: failed to create TXT record for %s: %w", challengeFQDN, err)
+	}
+
+	p.mu.Lock()
+	p.recordIDs[challengeFQDN] = created.Id
+	p.mu.Unlock()
+
+	log.Debug().Str("fqdn", challengeFQDN).Int("id", created.Id).Msg("Created ACME challenge TXT record")
+	return nil
+}
+
+// CleanUp removes the TXT record created by Present for fqdn, as expected by the
+// go-acme/lego challenge.Provider interface.
+func (p *OVHDNSProvider) CleanUp(fqdn, token, keyAuth string) error {
+	challengeFQDN, _ := dns01.GetRecord(fqdn, keyAuth)
+
+	p.mu.Lock()
+	id, ok := p.recordIDs[challengeFQDN]
+	delete(p.recordIDs, challengeFQDN)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("acme: no record ID tracked for %s", challengeFQDN)
+	}
+
+	if err := DeleteRecord(p.client, p.zone, id); err != nil {
+		return fmt.Errorf("acme: failed to delete TXT record for %s: %w", challengeFQDN, err)
+	}
+	return RefreshZone(p.client, p.zone)
+}
+
+// acmeSubdomain strips the provider's zone (and trailing dot) from a challenge FQDN
+// so it can be used as the "subDomain" field of an OVH record, e.g.
+// "_acme-challenge.home.example.com." with zone "example.com" becomes
+// "_acme-challenge.home".
+func (p *OVHDNSProvider) acmeSubdomain(fqdn string) string {
+	sub := strings.TrimSuffix(fqdn, ".")
+	sub = strings.TrimSuffix(sub, "."+p.zone)
+	return sub
+}
+
+// RunACMELoop obtains (and periodically renews) a single certificate covering
+// hostnames using the ACME DNS-01 challenge against the given OVH zone, writing
+// the resulting certificate and key as PEM files under certDir. The ACME account
+// key and registration are likewise persisted under certDir and reused across
+// restarts, so the account stays stable instead of a new one being registered
+// every time the process starts. It blocks, checking every renewInterval
+// whether the current certificate is due for renewal, until ctx is cancelled.
+// If reloadHook is non-empty, it is run through the shell after every
+// successful renewal so callers can signal a service to pick up the new
+// certificate.
+func RunACMELoop(ctx context.Context, client *ovh.Client, zone string, hostnames []string, email string, certDir string, renewInterval time.Duration, reloadHook string) error {
+	if len(hostnames) == 0 {
+		return fmt.Errorf("acme: at least one hostname is required")
+	}
+
+	if err := os.MkdirAll(certDir, 0o700); err != nil {
+		return fmt.Errorf("acme: failed to create cert directory: %w", err)
+	}
+
+	key, err := loadOrCreateAccountKey(certDir)
+	if err != nil {
+		return err
+	}
+	user := &acmeUser{Email: email, key: key}
+
+	legoConfig := lego.NewConfig(user)
+	legoConfig.Certificate.KeyType = certcrypto.EC256
+
+	legoClient, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return fmt.Errorf("acme: failed to create lego client: %w", err)
+	}
+
+	if err := legoClient.Challenge.SetDNS01Provider(NewOVHDNSProvider(client, zone)); err != nil {
+		return fmt.Errorf("acme: failed to register DNS-01 provider: %w", err)
+	}
+
+	reg, err := loadAccountRegistration(certDir)
+	if err != nil {
+		return err
+	}
+	if reg == nil {
+		reg, err = legoClient.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return fmt.Errorf("acme: failed to register ACME account: %w", err)
+		}
+		if err := saveAccountRegistration(certDir, reg); err != nil {
+			return err
+		}
+	}
+	user.Registration = reg
+
+	for {
+		if err := obtainAndWriteCertificate(legoClient, hostnames, certDir, reloadHook); err != nil {
+			log.Error().Err(err).Strs("hostnames", hostnames).Msg("Failed to obtain/renew certificate")
+		}
+
+		select {
+		case <-time.After(renewInterval):
+		case <-ctx.Done():
+			log.Info().Msg("Stopping ACME renewal loop")
+			return nil
+		}
+	}
+}
+
+// accountKeyFile and accountRegFile are the names, relative to certDir, under which the
+// ACME account's private key and registration resource are persisted so restarts reuse the
+// same account instead of registering a new one each time.
+const (
+	accountKeyFile = "account.key"
+	accountRegFile = "account.json"
+)
+
+// loadOrCreateAccountKey loads the ACME account private key persisted at
+// filepath.Join(certDir, accountKeyFile), generating and persisting a new one if none exists
+// yet. Reusing the same key across restarts keeps the ACME account identity stable; otherwise
+// a frequently-restarting process would register a fresh account every time and risk the CA's
+// new-account rate limit.
+func loadOrCreateAccountKey(certDir string) (*ecdsa.PrivateKey, error) {
+	keyPath := filepath.Join(certDir, accountKeyFile)
+
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("acme: account key file %q is not valid PEM", keyPath)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("acme: failed to parse account key: %w", err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("acme: failed to read account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to generate account key: %w", err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to marshal account key: %w", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, fmt.Errorf("acme: failed to write account key: %w", err)
+	}
+	return key, nil
+}
+
+// loadAccountRegistration loads a previously persisted ACME account registration from
+// filepath.Join(certDir, accountRegFile), returning a nil resource (and no error) if none has
+// been persisted yet.
+func loadAccountRegistration(certDir string) (*registration.Resource, error) {
+	data, err := os.ReadFile(filepath.Join(certDir, accountRegFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to read account registration: %w", err)
+	}
+
+	var reg registration.Resource
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return nil, fmt.Errorf("acme: failed to parse account registration: %w", err)
+	}
+	return &reg, nil
+}
+
+// saveAccountRegistration persists reg to filepath.Join(certDir, accountRegFile) so a future
+// start can reuse it instead of registering a new account.
+func saveAccountRegistration(certDir string, reg *registration.Resource) error {
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("acme: failed to marshal account registration: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(certDir, accountRegFile), data, 0o600); err != nil {
+		return fmt.Errorf("acme: failed to write account registration: %w", err)
+	}
+	return nil
+}
+
+// renewalWindow is how long before a certificate's expiry obtainAndWriteCertificate will
+// request a replacement. It mirrors the industry-standard 30-day renewal window used by
+// certbot and most other ACME clients.
+const renewalWindow = 30 * 24 * time.Hour
+
+// obtainAndWriteCertificate requests (or renews) a certificate for hostnames via legoClient,
+// writes the resulting PEM-encoded certificate and private key to certDir, and runs
+// reloadHook on success. If a certificate already on disk at certDir is not within
+// renewalWindow of expiring, it is left untouched and no ACME request is made.
+func obtainAndWriteCertificate(legoClient *lego.Client, hostnames []string, certDir string, reloadHook string) error {
+	certPath := filepath.Join(certDir, hostnames[0]+".crt")
+	keyPath := filepath.Join(certDir, hostnames[0]+".key")
+
+	dueForRenewal, err := certDueForRenewal(certPath)
+	if err != nil {
+		return err
+	}
+	if !dueForRenewal {
+		log.Debug().Strs("hostnames", hostnames).Str("certPath", certPath).Msg("Certificate not yet due for renewal")
+		return nil
+	}
+
+	request := certificate.ObtainRequest{Domains: hostnames, Bundle: true}
+	cert, err := legoClient.Certificate.Obtain(request)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(certPath, cert.Certificate, 0o644); err != nil {
+		return fmt.Errorf("acme: failed to write certificate: %w", err)
+	}
+	if err := os.WriteFile(keyPath, cert.PrivateKey, 0o600); err != nil {
+		return fmt.Errorf("acme: failed to write private key: %w", err)
+	}
+
+	// Sanity check that the written files form a valid keypair before signalling a reload.
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		return fmt.Errorf("acme: written certificate/key failed to load: %w", err)
+	}
+
+	log.Info().Strs("hostnames", hostnames).Str("certPath", certPath).Msg("Successfully renewed certificate")
+
+	if reloadHook != "" {
+		if err := runReloadHook(reloadHook); err != nil {
+			log.Error().Err(err).Str("hook", reloadHook).Msg("Reload hook failed")
+		}
+	}
+	return nil
+}
+
+// certDueForRenewal reports whether the certificate at certPath needs to be (re)obtained:
+// true if no certificate exists there yet, if it can't be parsed, or if it is within
+// renewalWindow of its NotAfter expiry.
+func certDueForRenewal(certPath string) (bool, error) {
+	data, err := os.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("acme: failed to read existing certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		log.Warn().Str("certPath", certPath).Msg("Existing certificate file is not valid PEM; renewing")
+		return true, nil
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		log.Warn().Err(err).Str("certPath", certPath).Msg("Existing certificate could not be parsed; renewing")
+		return true, nil
+	}
+
+	return time.Until(cert.NotAfter) < renewalWindow, nil
+}
+
+// runReloadHook executes hook through the shell, returning an error that includes its
+// combined output on failure.
+func runReloadHook(hook string) error {
+	cmd := exec.Command("sh", "-c", hook)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reload hook failed: %w: %s", err, string(out))
+	}
+	return nil
+}
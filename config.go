@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ovh/go-ovh/ovh"
+	"gopkg.in/yaml.v3"
+)
+
+const staticTargetPrefix = "static:"
+
+// Entry describes a single managed DNS entry within a Zone: what subdomain it lives at,
+// which record type(s) it covers, the TTL to use when creating it, and where its desired
+// target value comes from. TargetSource is used by A/AAAA/CNAME/TXT/CAA entries; SRV, TLSA
+// and SSHFP entries instead fill in their own structured block, since their target isn't a
+// single opaque value. For A/AAAA, TargetSource's "public-v4"/"public-v6" value must agree
+// with fieldType's address family; fieldType "both" therefore only accepts a static
+// TargetSource, since a single value can't mean "public-v4 for A, public-v6 for AAAA".
+type Entry struct {
+	Subdomain    string       `yaml:"subdomain"`
+	FieldType    string       `yaml:"fieldType"`
+	Ttl          int          `yaml:"ttl"`
+	TargetSource string       `yaml:"targetSource"`
+	SRV          *SRVTarget   `yaml:"srv"`
+	TLSA         *TLSATarget  `yaml:"tlsa"`
+	SSHFP        *SSHFPTarget `yaml:"sshfp"`
+}
+
+// Zone groups the entries managed within a single OVH DNS zone.
+type Zone struct {
+	Name    string  `yaml:"name"`
+	Entries []Entry `yaml:"entries"`
+}
+
+// Config is the top-level structure loaded from the YAML file referenced by CONFIG_FILE. It
+// declares every zone and subdomain entry this binary is responsible for keeping up to date.
+// PublicIPResolvers, if set, overrides the default order in which public IP discovery
+// methods are tried (see resolverRegistry); it is only consulted by A/AAAA entries whose
+// targetSource is "public-v4" or "public-v6".
+type Config struct {
+	Zones             []Zone   `yaml:"zones"`
+	PublicIPResolvers []string `yaml:"publicIPResolvers"`
+}
+
+// LoadConfig reads and parses the YAML configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %w", path, err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// fieldTypes expands an entry's configured fieldType ("A", "AAAA" or "both") into the
+// concrete OVH record types that must be reconciled for it.
+func (e Entry) fieldTypes() []string {
+	switch e.FieldType {
+	case "both":
+		return []string{"A", "AAAA"}
+	default:
+		return []string{e.FieldType}
+	}
+}
+
+// fetchZones lists the OVH zones available to the configured credentials. It is meant to be
+// called once at startup so Config can be validated against it before the main loop starts
+// driving any of the configured zones.
+func fetchZones(client *ovh.Client) ([]string, error) {
+	var zones []string
+	if err := client.Get("/domain/zone", &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// Validate checks that every zone in c is present in availableZones and that each entry
+// resolves to a supported fieldType with enough configuration to build its target. It
+// returns an error describing the first problem found.
+func (c *Config) Validate(availableZones []string) error {
+	known := make(map[string]bool, len(availableZones))
+	for _, z := range availableZones {
+		known[z] = true
+	}
+
+	for _, zone := range c.Zones {
+		if !known[zone.Name] {
+			return fmt.Errorf("zone %q is not one of the zones available to this OVH account", zone.Name)
+		}
+		for _, entry := range zone.Entries {
+			if err := entry.validateFieldConsistency(); err != nil {
+				return fmt.Errorf("zone %q: %w", zone.Name, err)
+			}
+			for _, fieldType := range entry.fieldTypes() {
+				switch fieldType {
+				case "A", "AAAA":
+					wantPublic := map[string]string{"A": "public-v4", "AAAA": "public-v6"}[fieldType]
+					switch {
+					case entry.TargetSource == wantPublic:
+					case strings.HasPrefix(entry.TargetSource, staticTargetPrefix):
+					case entry.TargetSource == "public-v4", entry.TargetSource == "public-v6":
+						return fmt.Errorf("zone %q: entry %q (%s) has targetSource %q, which resolves to the wrong address family", zone.Name, entry.Subdomain, fieldType, entry.TargetSource)
+					default:
+						return fmt.Errorf("zone %q: entry %q has unsupported targetSource %q", zone.Name, entry.Subdomain, entry.TargetSource)
+					}
+				case "CNAME", "TXT", "CAA":
+					if !strings.HasPrefix(entry.TargetSource, staticTargetPrefix) {
+						return fmt.Errorf("zone %q: entry %q (%s) requires a %q targetSource", zone.Name, entry.Subdomain, fieldType, staticTargetPrefix)
+					}
+				case "SRV":
+					if entry.SRV == nil {
+						return fmt.Errorf("zone %q: entry %q (SRV) requires an srv block", zone.Name, entry.Subdomain)
+					}
+				case "TLSA":
+					if entry.TLSA == nil {
+						return fmt.Errorf("zone %q: entry %q (TLSA) requires a tlsa block", zone.Name, entry.Subdomain)
+					}
+				case "SSHFP":
+					if entry.SSHFP == nil {
+						return fmt.Errorf("zone %q: entry %q (SSHFP) requires an sshfp block", zone.Name, entry.Subdomain)
+					}
+				default:
+					return fmt.Errorf("zone %q: entry %q has unsupported fieldType %q", zone.Name, entry.Subdomain, fieldType)
+				}
+			}
+		}
+	}
+
+	for _, name := range c.PublicIPResolvers {
+		if _, ok := resolverRegistry[name]; !ok {
+			return fmt.Errorf("publicIPResolvers: unknown resolver %q", name)
+		}
+	}
+	return nil
+}
+
+// validateFieldConsistency checks that an entry only sets the structured block (srv/tlsa/
+// sshfp) matching its fieldType, and that targetSource is left empty for those fieldTypes,
+// so a misconfigured entry (e.g. one setting both an srv and a tlsa block, or a static:
+// targetSource on an SRV entry) fails at startup instead of having the extra field silently
+// ignored.
+func (e Entry) validateFieldConsistency() error {
+	if e.FieldType != "SRV" && e.SRV != nil {
+		return fmt.Errorf("entry %q: srv block is set but fieldType is %q", e.Subdomain, e.FieldType)
+	}
+	if e.FieldType != "TLSA" && e.TLSA != nil {
+		return fmt.Errorf("entry %q: tlsa block is set but fieldType is %q", e.Subdomain, e.FieldType)
+	}
+	if e.FieldType != "SSHFP" && e.SSHFP != nil {
+		return fmt.Errorf("entry %q: sshfp block is set but fieldType is %q", e.Subdomain, e.FieldType)
+	}
+
+	switch e.FieldType {
+	case "SRV", "TLSA", "SSHFP":
+		if e.TargetSource != "" {
+			return fmt.Errorf("entry %q: targetSource %q is ignored by fieldType %q and must be left empty", e.Subdomain, e.TargetSource, e.FieldType)
+		}
+	}
+	return nil
+}
+
+// recordKey identifies a single managed entry across zone, subdomain and record type, so
+// the main loop can remember the previously-seen record(s) for each one independently.
+type recordKey struct {
+	Zone      string
+	Subdomain string
+	FieldType string
+}
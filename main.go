@@ -2,10 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -23,6 +20,7 @@ type record struct { // for client requests
 	Subdomain string `json:"subDomain"`
 	Target    string `json:"target"`
 	Ttl       int    `json:"ttl"`
+	Id        int    `json:"id,omitempty"`
 }
 
 type recAndID struct { // for our inner usage
@@ -33,8 +31,6 @@ type recAndID struct { // for our inner usage
 	Id        int
 }
 
-var domain string
-
 // getEnv retrieves the value of the specified environment variable.
 // If the environment variable is not set or is empty, it returns an error
 // indicating that the variable is required.
@@ -54,38 +50,51 @@ func getEnv(key string) (string, error) {
 	return value, nil
 }
 
-// getPublicIP retrieves the public IP address of the host machine.
-// If v6 is true, it fetches the IPv6 address using the api6.ipify.org service.
-// If v6 is false, it fetches the IPv4 address using the api.ipify.org service.
-// The function returns the IP address as a string, or an error if the request fails,
-// the response status is not OK, or the IP is not found in the response.
-func getPublicIP(v6 bool) (string, error) {
-	var resp *http.Response
-	var err error
-	if v6 {
-		resp, err = http.Get("https://api6.ipify.org?format=json")
-	} else {
-		resp, err = http.Get("https://api.ipify.org?format=json")
+// getEnvOrDefault retrieves the value of the specified environment variable, or returns def
+// if it is not set.
+func getEnvOrDefault(key string, def string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
 	}
+	return value
+}
 
-	if err != nil {
-		return "", err
+// getEnvDurationOrDefault retrieves the value of the specified environment variable as a
+// number of seconds and returns it as a time.Duration. If the environment variable is not
+// set, def is returned instead. An error is returned if the variable is set but is not a
+// valid integer number of seconds.
+func getEnvDurationOrDefault(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to get public IP, status code : %d", resp.StatusCode)
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer number of seconds: %w", key, err)
 	}
+	return time.Duration(seconds) * time.Second, nil
+}
 
-	var result map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+// publicIPChain is the ordered chain of PublicIPResolvers used by getPublicIP. It is built
+// from Config.PublicIPResolvers in main before the reconciliation loop starts.
+var publicIPChain *PublicIPChain
+
+// getPublicIP retrieves the host's current public IP address for the requested family by
+// trying publicIPChain's resolvers in order, and only fails once every one of them has. On
+// success it records the address in the govh_current_public_ip metric.
+func getPublicIP(v6 bool) (string, error) {
+	ip, err := publicIPChain.Resolve(v6)
+	if err != nil {
 		return "", err
 	}
 
-	ip := result["ip"]
-	if ip == "" {
-		return "", errors.New("public IP not found in response")
+	family := "v4"
+	if v6 {
+		family = "v6"
 	}
+	recordCurrentPublicIP(family, ip)
 
 	return ip, nil
 }
@@ -126,50 +135,67 @@ func NewOVHClient() (*ovh.Client, error) {
 }
 
 // IDToRecord converts a record ID to a record struct by making a GET request
-func IDToRecord(client *ovh.Client, id int) (record, error) {
+func IDToRecord(client *ovh.Client, zone string, id int) (record, error) {
 	var info record
-	err := client.Get(fmt.Sprintf("/domain/zone/%s/record/%d", domain, id), &info)
+	err := client.Get(fmt.Sprintf("/domain/zone/%s/record/%d", zone, id), &info)
+	observeOVHRequest("get", err)
 	if err != nil {
 		return record{}, err
 	}
 	return info, nil
 }
 
-// PostNewRecord creates a new DNS record in the specified domain zone using the provided OVH client and record data.
+// PostNewRecord creates a new DNS record in the given zone using the provided OVH client and record data.
 // After successfully posting the new record, it refreshes the DNS zone to apply the changes.
+// It returns the created record, including the Id assigned by the OVH API, so callers that need to
+// reference the record later (e.g. to delete it) don't have to poll for it again.
 // Returns an error if the record creation or zone refresh fails.
-func PostNewRecord(client *ovh.Client, rec record) error {
+func PostNewRecord(client *ovh.Client, zone string, rec record) (record, error) {
 	var resp record
-	err := client.Post(fmt.Sprintf("/domain/zone/%s/record", domain), rec, &resp)
+	err := client.Post(fmt.Sprintf("/domain/zone/%s/record", zone), rec, &resp)
+	observeOVHRequest("post", err)
 	if err != nil {
-		return err
+		return record{}, err
+	}
+
+	err = RefreshZone(client, zone)
+	if err != nil {
+		return record{}, err
 	}
+	return resp, nil
+}
 
-	err = RefreshZone(client)
+// DeleteRecord deletes the DNS record identified by id from the given zone using the
+// provided OVH client. Returns an error if the API request fails.
+func DeleteRecord(client *ovh.Client, zone string, id int) error {
+	err := client.Delete(fmt.Sprintf("/domain/zone/%s/record/%d", zone, id), nil)
+	observeOVHRequest("delete", err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// UpdateRecord updates a DNS record in the specified domain zone using the provided OVH client.
+// UpdateRecord updates a DNS record in the given zone using the provided OVH client.
 // It sends a PUT request to the OVH API to update the record with the given ID and record data.
 // Returns an error if the update operation fails.
-func UpdateRecord(client *ovh.Client, rec record, id int) error {
+func UpdateRecord(client *ovh.Client, zone string, rec record, id int) error {
 
 	var resp record
-	err := client.Put(fmt.Sprintf("/domain/zone/%s/record/%d", domain, id), rec, resp)
+	err := client.Put(fmt.Sprintf("/domain/zone/%s/record/%d", zone, id), rec, resp)
+	observeOVHRequest("put", err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// RefreshZone triggers a refresh of the DNS zone for the specified domain using the provided OVH client.
+// RefreshZone triggers a refresh of the given DNS zone using the provided OVH client.
 // It sends a POST request to the OVH API to update the zone records.
 // Returns an error if the API request fails.
-func RefreshZone(client *ovh.Client) error {
-	err := client.Post(fmt.Sprintf("/domain/zone/%s/refresh", domain), nil, nil)
+func RefreshZone(client *ovh.Client, zone string) error {
+	err := client.Post(fmt.Sprintf("/domain/zone/%s/refresh", zone), nil, nil)
+	observeOVHRequest("refresh", err)
 	if err != nil {
 		return err
 	}
@@ -209,23 +235,24 @@ func ConnAttempt(client *ovh.Client) error {
 	return nil
 }
 
-// PollRecords retrieves DNS records of a specified field type from the OVH API for the configured domain,
-// filtering them to include only those whose target matches the provided public IP address.
-// It returns a slice of recAndID structs containing details of the matching records and their IDs.
-// If an error occurs during the API call, it returns nil and the error.
-func PollRecords(client *ovh.Client, fieldType string, pubIP string) ([]recAndID, error) {
+// PollRecords retrieves DNS records of a specified field type and subdomain from the OVH API
+// for the given zone, filtering them to include only those whose target matches the desired
+// value. It returns a slice of recAndID structs containing details of the matching records
+// and their IDs. If an error occurs during the API call, it returns nil and the error.
+func PollRecords(client *ovh.Client, zone string, fieldType string, subdomain string, target string) ([]recAndID, error) {
 	var recordsIDs []int
 	var records []recAndID
-	err := client.Get(fmt.Sprintf("/domain/zone/%s/record?fieldType=%s", domain, fieldType), &recordsIDs)
+	err := client.Get(fmt.Sprintf("/domain/zone/%s/record?fieldType=%s&subDomain=%s", zone, fieldType, subdomain), &recordsIDs)
+	observeOVHRequest("get", err)
 	if err != nil {
 		return nil, err
 	}
 	for _, id := range recordsIDs {
-		rec, err := IDToRecord(client, id)
+		rec, err := IDToRecord(client, zone, id)
 		if err != nil {
 			log.Error().Err(err).Msgf("Failed to retrieve info for record ID : %d", id)
 		} else {
-			if rec.Target == pubIP {
+			if rec.Target == target {
 				records = append(records, recAndID{
 					FieldType: rec.FieldType,
 					Subdomain: rec.Subdomain,
@@ -233,66 +260,87 @@ func PollRecords(client *ovh.Client, fieldType string, pubIP string) ([]recAndID
 					Ttl:       rec.Ttl,
 					Id:        id,
 				})
-				log.Debug().Str("type", rec.FieldType).Str("Subdomain", rec.Subdomain).Str("IP", rec.Target).Msg("Matching record found")
+				log.Debug().Str("zone", zone).Str("type", rec.FieldType).Str("Subdomain", rec.Subdomain).Str("target", rec.Target).Msg("Matching record found")
 			}
 		}
 	}
 	return records, nil
 }
 
-// ManageRecords manages DNS records for a given field type and public IP address.
-// It polls existing records and updates or creates them as necessary.
-// If no records exist and there are no previous records, it creates a new record.
-// If previous records exist but no current records match, it updates the previous records with the new public IP.
-// After updating, it refreshes the DNS zone.
+// ManageRecords reconciles a single managed entry (zone, subdomain, field type) against its
+// desired target value. It polls existing records and updates or creates them as necessary.
+// If no records exist and there are no previous records, it creates a new record. If previous
+// records exist but no current records match, it updates the previous records with the new
+// target. After updating, it refreshes the DNS zone and waits for the change to propagate.
 // Returns the updated list of records and any error encountered.
 //
 // Parameters:
 //   client    - OVH API client used for DNS operations.
+//   zone      - OVH zone the entry belongs to.
 //   previous  - Slice of previous DNS records and their IDs.
 //   fieldType - DNS record type (e.g., "A", "AAAA").
-//   pubIP     - Public IP address to set in the DNS records.
+//   subdomain - Subdomain the entry is managed under.
+//   ttl       - TTL to use when creating a new record.
+//   target    - Desired target value for the DNS record.
 //
 // Returns:
 //   []recAndID - Updated slice of DNS records and their IDs.
 //   error      - Error encountered during the operation, if any.
-func ManageRecords(client *ovh.Client, previous []recAndID, fieldType string, pubIP string) ([]recAndID, error) {
-	records, err := PollRecords(client, fieldType, pubIP)
+func ManageRecords(client *ovh.Client, zone string, previous []recAndID, fieldType string, subdomain string, ttl int, target string) ([]recAndID, error) {
+	start := time.Now()
+	defer func() {
+		recordUpdateDuration.WithLabelValues(zone, subdomain, fieldType).Observe(time.Since(start).Seconds())
+	}()
+
+	records, err := PollRecords(client, zone, fieldType, subdomain, target)
 	if err != nil {
-		log.Error().Err(err).Msgf("Failed to get %s records list", fieldType)
+		log.Error().Err(err).Str("zone", zone).Str("subdomain", subdomain).Msgf("Failed to get %s records list", fieldType)
 		return nil, err
 	}
 
 	if len(records) == 0 {
 		if len(previous) == 0 {
-			rec := NewRecord(fieldType, "", pubIP, 0)
-			err = PostNewRecord(client, *rec)
+			rec := NewRecord(fieldType, subdomain, target, ttl)
+			_, err = PostNewRecord(client, zone, *rec)
 			if err != nil {
-				log.Error().Err(err).Str("IP", rec.Target).Int("TTL", rec.Ttl).Msg("Failed to add record")
+				log.Error().Err(err).Str("zone", zone).Str("target", rec.Target).Int("TTL", rec.Ttl).Msg("Failed to add record")
 				return nil, err
 			} else {
-				log.Info().Str("IP", rec.Target).Int("TTL", rec.Ttl).Msg("Sucessfully added record")
+				log.Info().Str("zone", zone).Str("subdomain", subdomain).Str("target", rec.Target).Int("TTL", rec.Ttl).Msg("Sucessfully added record")
+				if err := WaitForPropagation(client, zone, *rec, target); err != nil {
+					log.Error().Err(err).Msg("DNS propagation could not be confirmed")
+					return nil, err
+				}
 			}
 		} else {
 			for _, prev := range previous {
-				rec := NewRecord(prev.FieldType, prev.Subdomain, pubIP, prev.Ttl)
-				err := UpdateRecord(client, *rec, prev.Id)
+				rec := NewRecord(prev.FieldType, prev.Subdomain, target, prev.Ttl)
+				err := UpdateRecord(client, zone, *rec, prev.Id)
 				if err != nil {
-					log.Error().Err(err).Str("type", prev.FieldType).Str("Subdomain", prev.Subdomain).Str("IP", prev.Target).Msg("Failed to update record")
+					log.Error().Err(err).Str("zone", zone).Str("type", prev.FieldType).Str("Subdomain", prev.Subdomain).Str("target", prev.Target).Msg("Failed to update record")
 				} else {
-					log.Debug().Int("ID", prev.Id).Str("Type", prev.FieldType).Str("Subdomain", prev.Subdomain).Msg("Sucessfully updated record")
+					log.Debug().Str("zone", zone).Int("ID", prev.Id).Str("Type", prev.FieldType).Str("Subdomain", prev.Subdomain).Msg("Sucessfully updated record")
 				}
 			}
-			err := RefreshZone(client)
+			err := RefreshZone(client, zone)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to refresh DNS zone")
+				log.Error().Err(err).Str("zone", zone).Msg("Failed to refresh DNS zone")
+			}
+			log.Info().Msgf("Updated %s records for %s.%s with new target [%s]", fieldType, subdomain, zone, target)
+
+			for _, prev := range previous {
+				rec := NewRecord(prev.FieldType, prev.Subdomain, target, prev.Ttl)
+				if err := WaitForPropagation(client, zone, *rec, target); err != nil {
+					log.Error().Err(err).Msg("DNS propagation could not be confirmed")
+					return nil, err
+				}
 			}
-			log.Info().Msgf("Updated %s records with new public IP [%s]", fieldType, pubIP)
 		}
 	} else {
-		log.Info().Msgf("Public ip [%s] successfully found in %s record(s)", pubIP, fieldType)
+		log.Info().Msgf("Target [%s] successfully found in %s record(s) for %s.%s", target, fieldType, subdomain, zone)
 		previous = records
 	}
+	lastSuccessfulUpdate.WithLabelValues(zone, subdomain, fieldType).Set(float64(time.Now().Unix()))
 	return previous, nil
 }
 
@@ -313,6 +361,34 @@ func main() {
 		Default: 1,
 	})
 
+	acmeCmd := parser.NewCommand("acme", "Run the ACME DNS-01 certificate renewal loop, using OVH TXT records to solve challenges")
+	acmeZone := acmeCmd.String("", "zone", &argparse.Options{
+		Required: true,
+		Help:     "OVH zone the ACME DNS-01 challenge TXT records are created in",
+	})
+	acmeHostnames := acmeCmd.StringList("", "hostname", &argparse.Options{
+		Required: true,
+		Help:     "Hostname to request a certificate for, can be repeated to cover several SANs",
+	})
+	acmeEmail := acmeCmd.String("", "email", &argparse.Options{
+		Required: true,
+		Help:     "Contact email used when registering the ACME account",
+	})
+	acmeCertDir := acmeCmd.String("", "cert-dir", &argparse.Options{
+		Required: false,
+		Default:  "./certs",
+		Help:     "Directory PEM-encoded certificates and keys are written to",
+	})
+	acmeRenewInterval := acmeCmd.Int("", "renew-check-interval", &argparse.Options{
+		Required: false,
+		Default:  43200,
+		Help:     "How often, in seconds, to check whether the certificate needs renewing",
+	})
+	acmeReloadHook := acmeCmd.String("", "reload-hook", &argparse.Options{
+		Required: false,
+		Help:     "Optional shell command run after a successful renewal, e.g. to reload a TLS-terminating service",
+	})
+
 	err := parser.Parse(os.Args)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to parse args")
@@ -330,11 +406,17 @@ func main() {
 		cancel()
 	}()
 
-	domain, err = getEnv("DOMAIN")
+	PropagationTimeout, err = getEnvDurationOrDefault("OVH_PROPAGATION_TIMEOUT", PropagationTimeout)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to get DOMAIN env variable")
+		log.Fatal().Err(err).Msg("Failed to get OVH_PROPAGATION_TIMEOUT env variable")
+	}
+	PollingInterval, err = getEnvDurationOrDefault("OVH_POLLING_INTERVAL", PollingInterval)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to get OVH_POLLING_INTERVAL env variable")
 	}
 
+	go StartMetricsServer(getEnvOrDefault("METRICS_ADDR", ":9090"))
+
 	client, err := NewOVHClient()
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create OVH client")
@@ -346,6 +428,36 @@ func main() {
 	}
 	log.Info().Msg("Successfully established connection to OVH API")
 
+	if acmeCmd.Happened() {
+		err = RunACMELoop(ctx, client, *acmeZone, *acmeHostnames, *acmeEmail, *acmeCertDir, time.Duration(*acmeRenewInterval)*time.Second, *acmeReloadHook)
+		if err != nil {
+			log.Fatal().Err(err).Msg("ACME renewal loop failed")
+		}
+		return
+	}
+
+	configFile, err := getEnv("CONFIG_FILE")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to get CONFIG_FILE env variable")
+	}
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config file")
+	}
+
+	availableZones, err := fetchZones(client)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to list zones available to this OVH account")
+	}
+	if err := cfg.Validate(availableZones); err != nil {
+		log.Fatal().Err(err).Msg("Invalid config file")
+	}
+
+	publicIPChain, err = BuildPublicIPChain(cfg.PublicIPResolvers)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build public IP resolver chain")
+	}
+
 	interval, err := getEnv("TIME_INTERVAL")
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to get time interval")
@@ -355,29 +467,29 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to convert time interval to int")
 	}
 
-	var previousRecs = map[string][]recAndID{
-		"A":    {},
-		"AAAA": {},
-	}
+	previousRecs := map[recordKey][]recAndID{}
 
 	for {
 		select {
 		case <-time.After(time.Duration(timeInterval) * time.Second):
-			for _, fieldType := range []string{"A", "AAAA"} {
-				// Poll public IP
-				pubIP, err := getPublicIP(fieldType == "AAAA")
-				if err != nil {
-					log.Error().Err(err).Msgf("Failed to get public IP for type %s", fieldType)
-					continue
-				}
-				// Manage records
-				records, err := ManageRecords(client, previousRecs[fieldType], fieldType, pubIP)
-				if err != nil {
-					log.Error().Err(err).Msgf("Failed to manage %s records", fieldType)
-					continue
+			for _, zone := range cfg.Zones {
+				for _, entry := range zone.Entries {
+					for _, fieldType := range entry.fieldTypes() {
+						target, err := entry.ResolveTargetForType(fieldType)
+						if err != nil {
+							log.Error().Err(err).Str("zone", zone.Name).Str("subdomain", entry.Subdomain).Msgf("Failed to resolve %s target", fieldType)
+							continue
+						}
+
+						key := recordKey{Zone: zone.Name, Subdomain: entry.Subdomain, FieldType: fieldType}
+						records, err := ManageRecords(client, zone.Name, previousRecs[key], fieldType, entry.Subdomain, entry.Ttl, target)
+						if err != nil {
+							log.Error().Err(err).Str("zone", zone.Name).Str("subdomain", entry.Subdomain).Msgf("Failed to manage %s records", fieldType)
+							continue
+						}
+						previousRecs[key] = records
+					}
 				}
-				previousRecs[fieldType] = records
-
 			}
 		case <-ctx.Done():
 			log.Info().Msg("Closing program")
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	currentPublicIP = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "govh_current_public_ip",
+		Help: "The most recently observed public IP address for this host, one series per address family, exposed info-style with the IP in a label.",
+	}, []string{"family", "ip"})
+
+	lastSuccessfulUpdate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "govh_last_successful_update_timestamp_seconds",
+		Help: "Unix timestamp of the last time a managed DNS entry was successfully reconciled.",
+	}, []string{"zone", "subdomain", "type"})
+
+	ovhAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "govh_ovh_api_requests_total",
+		Help: "Total number of requests made to the OVH API, by operation and result.",
+	}, []string{"op", "result"})
+
+	ipifyFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "govh_ipify_failures_total",
+		Help: "Total number of failed public IP lookups made through the ipify resolver.",
+	})
+
+	recordUpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "govh_record_update_duration_seconds",
+		Help: "Time taken to reconcile a single managed DNS entry.",
+	}, []string{"zone", "subdomain", "type"})
+
+	propagationWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "govh_propagation_wait_seconds",
+		Help: "Time spent waiting for a DNS update to propagate to every nameserver of a zone.",
+	}, []string{"zone"})
+)
+
+// StartMetricsServer starts an HTTP server on addr exposing Prometheus metrics at /metrics
+// and a liveness probe at /healthz. It blocks until the server stops, so callers run it in
+// its own goroutine.
+func StartMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	log.Info().Str("addr", addr).Msg("Starting metrics server")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Error().Err(err).Msg("Metrics server stopped")
+	}
+}
+
+var (
+	lastPublicIPMu sync.Mutex
+	lastPublicIP   = map[string]string{}
+)
+
+// recordCurrentPublicIP updates the govh_current_public_ip info-style metric for family,
+// clearing the series for any previously observed IP so a changed address doesn't leave a
+// stale label combination behind.
+func recordCurrentPublicIP(family string, ip string) {
+	lastPublicIPMu.Lock()
+	defer lastPublicIPMu.Unlock()
+
+	if previous, ok := lastPublicIP[family]; ok && previous != ip {
+		currentPublicIP.DeleteLabelValues(family, previous)
+	}
+	lastPublicIP[family] = ip
+	currentPublicIP.WithLabelValues(family, ip).Set(1)
+}
+
+// observeOVHRequest records the outcome of a call to the OVH API for op (e.g. "get", "post",
+// "put", "delete").
+func observeOVHRequest(op string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	ovhAPIRequests.WithLabelValues(op, result).Inc()
+}
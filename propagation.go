@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ovh/go-ovh/ovh"
+	"github.com/rs/zerolog/log"
+)
+
+// PropagationTimeout and PollingInterval bound how long ManageRecords waits for a DNS
+// update to become visible on every nameserver of the configured zone, and how often it
+// re-checks in the meantime. They are set from the OVH_PROPAGATION_TIMEOUT and
+// OVH_POLLING_INTERVAL environment variables (in seconds) in main, falling back to these
+// defaults, mirroring the pattern used by lego's OVH DNS provider config.
+var (
+	PropagationTimeout = 2 * time.Minute
+	PollingInterval    = 4 * time.Second
+)
+
+// PublicResolvers are queried in addition to the zone's own authoritative nameservers, so
+// propagation is only reported successful once the public resolvers that mail servers, ACME
+// CAs and end users actually rely on have also picked up the new value, not just the
+// authoritative servers OVH serves from directly.
+var PublicResolvers = []string{
+	"8.8.8.8",        // Google Public DNS
+	"1.1.1.1",        // Cloudflare
+	"9.9.9.9",        // Quad9
+}
+
+// getZoneNameServers returns the hostnames of the nameservers OVH has configured for zone.
+func getZoneNameServers(client *ovh.Client, zone string) ([]string, error) {
+	var ids []int
+	if err := client.Get(fmt.Sprintf("/domain/zone/%s/nameServer", zone), &ids); err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, id := range ids {
+		var info struct {
+			Host string `json:"host"`
+		}
+		if err := client.Get(fmt.Sprintf("/domain/zone/%s/nameServer/%d", zone, id), &info); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, info.Host)
+	}
+	return hosts, nil
+}
+
+// recordFQDN builds the fully-qualified, dot-terminated domain name for rec within zone.
+func recordFQDN(zone string, rec record) string {
+	if rec.Subdomain == "" {
+		return dns.Fqdn(zone)
+	}
+	return dns.Fqdn(rec.Subdomain + "." + zone)
+}
+
+// WaitForPropagation polls every nameserver of zone, plus PublicResolvers, until each one
+// answers rec's FQDN with expected, or PropagationTimeout elapses. Checking PublicResolvers
+// as well as the zone's own authoritative nameservers matters because downstream consumers
+// of the record (mail, ACME validation, end users) go through ordinary recursive resolvers,
+// not the authoritative servers directly. It supports every fieldType this binary can manage
+// (see answerMatches), though for the structured SRV/TLSA/SSHFP/CAA types it only confirms a
+// record of the right type exists, not that its fields match expected, since comparing
+// structured RDATA against the OVH API's flat target string isn't reliable. It logs the
+// outcome for each server as a structured field so operators can see exactly which ones have,
+// or have not yet, picked up the change. Returns an error if the timeout elapses before every
+// server reports the expected value.
+func WaitForPropagation(client *ovh.Client, zone string, rec record, expected string) error {
+	start := time.Now()
+	defer func() {
+		propagationWait.WithLabelValues(zone).Observe(time.Since(start).Seconds())
+	}()
+
+	nameservers, err := getZoneNameServers(client, zone)
+	if err != nil {
+		return fmt.Errorf("failed to list nameservers for zone %s: %w", zone, err)
+	}
+	if len(nameservers) == 0 {
+		return fmt.Errorf("zone %s has no nameservers to check propagation against", zone)
+	}
+	servers := append(append([]string{}, nameservers...), PublicResolvers...)
+
+	qType, ok := dns.StringToType[rec.FieldType]
+	if !ok {
+		return fmt.Errorf("propagation: unsupported fieldType %q", rec.FieldType)
+	}
+	target := recordFQDN(zone, rec)
+
+	deadline := time.Now().Add(PropagationTimeout)
+	pending := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		pending[s] = true
+	}
+
+	for {
+		event := log.Info().Str("fqdn", target).Str("expected", expected)
+		for s := range pending {
+			ok, err := queryNameserver(s, target, qType, rec.FieldType, expected)
+			if err != nil {
+				log.Debug().Err(err).Str("server", s).Str("fqdn", target).Msg("Propagation check failed")
+				continue
+			}
+			if ok {
+				delete(pending, s)
+			}
+			event = event.Bool(s, ok)
+		}
+		event.Msgf("Propagation status for %s", target)
+
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			remaining := make([]string, 0, len(pending))
+			for s := range pending {
+				remaining = append(remaining, s)
+			}
+			return fmt.Errorf("propagation timed out after %s, still pending on %v", PropagationTimeout, remaining)
+		}
+		time.Sleep(PollingInterval)
+	}
+}
+
+// queryNameserver asks server directly for fqdn/qType and reports whether any answer matches
+// expected, per answerMatches.
+func queryNameserver(server string, fqdn string, qType uint16, fieldType string, expected string) (bool, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(fqdn, qType)
+
+	client := &dns.Client{Timeout: 5 * time.Second}
+	resp, _, err := client.Exchange(msg, net.JoinHostPort(server, "53"))
+	if err != nil {
+		return false, err
+	}
+
+	for _, ans := range resp.Answer {
+		if answerMatches(ans, fieldType, expected) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// answerMatches reports whether rr satisfies expected for fieldType. A/AAAA/CNAME/TXT are
+// compared against expected's actual value; the structured SRV/TLSA/SSHFP/CAA types are only
+// checked for presence of a record of the right type, since their RDATA isn't in a form this
+// package can reliably compare against the OVH API's flat target string.
+func answerMatches(rr dns.RR, fieldType string, expected string) bool {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A.String() == expected
+	case *dns.AAAA:
+		return v.AAAA.String() == expected
+	case *dns.CNAME:
+		return strings.TrimSuffix(v.Target, ".") == strings.TrimSuffix(expected, ".")
+	case *dns.TXT:
+		return strings.Join(v.Txt, "") == expected
+	case *dns.SRV, *dns.TLSA, *dns.SSHFP, *dns.CAA:
+		return rr.Header().Rrtype == dns.StringToType[fieldType]
+	default:
+		return false
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pion/stun"
+)
+
+// PublicIPResolver discovers the host's current public IP address for a given address
+// family. Implementations should return an error rather than a guess when they can't
+// determine the address with confidence.
+type PublicIPResolver interface {
+	Name() string
+	Resolve(v6 bool) (string, error)
+}
+
+// httpIPResolver discovers the public IP by requesting a per-family URL and handing the
+// response body to parse.
+type httpIPResolver struct {
+	name  string
+	url4  string
+	url6  string
+	parse func([]byte) (string, error)
+}
+
+func (r *httpIPResolver) Name() string { return r.name }
+
+func (r *httpIPResolver) Resolve(v6 bool) (string, error) {
+	url := r.url4
+	if v6 {
+		url = r.url6
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: unexpected status code %d", r.name, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return r.parse(body)
+}
+
+func parsePlainTextIP(body []byte) (string, error) {
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("response is not a valid IP address: %q", ip)
+	}
+	return ip, nil
+}
+
+func parseIpifyJSON(body []byte) (string, error) {
+	var result map[string]string
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	ip := result["ip"]
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("response is not a valid IP address: %q", ip)
+	}
+	return ip, nil
+}
+
+// ipifyResolver wraps httpIPResolver so a failed lookup is also reflected in the
+// govh_ipify_failures_total metric.
+type ipifyResolver struct {
+	*httpIPResolver
+}
+
+func (r ipifyResolver) Resolve(v6 bool) (string, error) {
+	ip, err := r.httpIPResolver.Resolve(v6)
+	if err != nil {
+		ipifyFailures.Inc()
+	}
+	return ip, err
+}
+
+// openDNSResolver discovers the public IP the way "dig +short myip.opendns.com
+// @resolver1.opendns.com" does: by asking an OpenDNS resolver to resolve a name it
+// special-cases to mean "the address you're asking from".
+type openDNSResolver struct {
+	server string
+}
+
+func (openDNSResolver) Name() string { return "opendns" }
+
+func (r openDNSResolver) Resolve(v6 bool) (string, error) {
+	qType := dns.TypeA
+	if v6 {
+		qType = dns.TypeAAAA
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("myip.opendns.com.", qType)
+
+	client := &dns.Client{Timeout: 5 * time.Second}
+	resp, _, err := client.Exchange(msg, net.JoinHostPort(r.server, "53"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, ans := range resp.Answer {
+		switch rr := ans.(type) {
+		case *dns.A:
+			return rr.A.String(), nil
+		case *dns.AAAA:
+			return rr.AAAA.String(), nil
+		}
+	}
+	return "", fmt.Errorf("opendns: no %s record in response", dns.TypeToString[qType])
+}
+
+// localInterfaceResolver reads the host's own network interfaces, looking for a routable
+// address of the requested family. It's useful for hosts that have a routable IPv6 address
+// configured directly, without NAT, so no external lookup is needed.
+type localInterfaceResolver struct{}
+
+func (localInterfaceResolver) Name() string { return "local-interface" }
+
+func (localInterfaceResolver) Resolve(v6 bool) (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		isV6 := ipNet.IP.To4() == nil
+		if isV6 != v6 || !ipNet.IP.IsGlobalUnicast() || !isPublishableIP(ipNet.IP) {
+			continue
+		}
+		return ipNet.IP.String(), nil
+	}
+	return "", fmt.Errorf("no routable address found on local interfaces")
+}
+
+// stunResolver discovers the host's public IPv4 address behind NAT using a STUN (RFC 5389)
+// binding request.
+type stunResolver struct {
+	server string
+}
+
+func (stunResolver) Name() string { return "stun" }
+
+func (r stunResolver) Resolve(v6 bool) (string, error) {
+	if v6 {
+		return "", fmt.Errorf("stun: IPv6 is not supported")
+	}
+
+	conn, err := net.Dial("udp4", r.server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	client, err := stun.NewClient(conn)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	var ip string
+	var resolveErr error
+	message := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if err := client.Do(message, func(res stun.Event) {
+		if res.Error != nil {
+			resolveErr = res.Error
+			return
+		}
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(res.Message); err != nil {
+			resolveErr = err
+			return
+		}
+		ip = xorAddr.IP.String()
+	}); err != nil {
+		return "", err
+	}
+	return ip, resolveErr
+}
+
+// isPublishableIP rejects loopback, link-local, RFC1918, CGNAT and unique-local addresses,
+// so a misbehaving resolver can't get a private address published to OVH.
+func isPublishableIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+
+	privateBlocks := []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"100.64.0.0/10", // CGNAT
+		"fc00::/7",      // unique local
+	}
+	for _, cidr := range privateBlocks {
+		_, block, err := net.ParseCIDR(cidr)
+		if err == nil && block.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolverRegistry maps the resolver names accepted in Config.PublicIPResolvers to their
+// implementation.
+var resolverRegistry = map[string]PublicIPResolver{
+	"ipify": ipifyResolver{&httpIPResolver{
+		name: "ipify",
+		url4: "https://api.ipify.org?format=json",
+		url6: "https://api6.ipify.org?format=json",
+		parse: parseIpifyJSON,
+	}},
+	"icanhazip": &httpIPResolver{
+		name:  "icanhazip",
+		url4:  "https://ipv4.icanhazip.com",
+		url6:  "https://ipv6.icanhazip.com",
+		parse: parsePlainTextIP,
+	},
+	"ifconfig.co": &httpIPResolver{
+		name:  "ifconfig.co",
+		url4:  "https://ifconfig.co/ip",
+		url6:  "https://v6.ifconfig.co/ip",
+		parse: parsePlainTextIP,
+	},
+	"opendns":         openDNSResolver{server: "resolver1.opendns.com"},
+	"local-interface": localInterfaceResolver{},
+	"stun":            stunResolver{server: "stun.l.google.com:19302"},
+}
+
+// defaultPublicIPResolvers is the order resolvers are tried in when Config.PublicIPResolvers
+// is left empty.
+var defaultPublicIPResolvers = []string{"ipify", "icanhazip", "ifconfig.co", "opendns", "local-interface", "stun"}
+
+// PublicIPChain tries an ordered list of PublicIPResolvers per address family, remembering
+// the last one that succeeded so the next tick tries it first, and only returns an error
+// once every resolver has failed.
+type PublicIPChain struct {
+	resolvers []PublicIPResolver
+
+	mu       sync.Mutex
+	lastGood map[bool]PublicIPResolver
+}
+
+// NewPublicIPChain returns a PublicIPChain that tries resolvers in the given order.
+func NewPublicIPChain(resolvers []PublicIPResolver) *PublicIPChain {
+	return &PublicIPChain{
+		resolvers: resolvers,
+		lastGood:  make(map[bool]PublicIPResolver),
+	}
+}
+
+// BuildPublicIPChain resolves a list of resolver names (as used in Config.PublicIPResolvers)
+// against resolverRegistry and returns the resulting chain. An empty names falls back to
+// defaultPublicIPResolvers.
+func BuildPublicIPChain(names []string) (*PublicIPChain, error) {
+	if len(names) == 0 {
+		names = defaultPublicIPResolvers
+	}
+
+	resolvers := make([]PublicIPResolver, 0, len(names))
+	for _, name := range names {
+		r, ok := resolverRegistry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown public IP resolver %q", name)
+		}
+		resolvers = append(resolvers, r)
+	}
+	return NewPublicIPChain(resolvers), nil
+}
+
+// Resolve tries every resolver in the chain, in order (with the last resolver that
+// succeeded for this family tried first), rejecting any address that isn't publishable or
+// whose family doesn't match the requested one (a resolver can return the wrong family, e.g.
+// if a dual-stack HTTP request happens to land on the other address). It returns an error
+// only once every resolver has failed.
+func (c *PublicIPChain) Resolve(v6 bool) (string, error) {
+	var errs []string
+	for _, r := range c.orderedResolvers(v6) {
+		ip, err := r.Resolve(v6)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", r.Name(), err))
+			continue
+		}
+
+		parsed := net.ParseIP(ip)
+		if parsed == nil || !isPublishableIP(parsed) {
+			errs = append(errs, fmt.Sprintf("%s: returned non-publishable address %q", r.Name(), ip))
+			continue
+		}
+		if isV6 := parsed.To4() == nil; isV6 != v6 {
+			errs = append(errs, fmt.Sprintf("%s: returned wrong address family %q", r.Name(), ip))
+			continue
+		}
+
+		c.mu.Lock()
+		c.lastGood[v6] = r
+		c.mu.Unlock()
+		return ip, nil
+	}
+	return "", fmt.Errorf("all public IP resolvers failed: %s", strings.Join(errs, "; "))
+}
+
+// orderedResolvers returns the chain's resolvers with the last one that succeeded for this
+// family moved to the front, if any.
+func (c *PublicIPChain) orderedResolvers(v6 bool) []PublicIPResolver {
+	c.mu.Lock()
+	last, ok := c.lastGood[v6]
+	c.mu.Unlock()
+	if !ok {
+		return c.resolvers
+	}
+
+	ordered := make([]PublicIPResolver, 0, len(c.resolvers))
+	ordered = append(ordered, last)
+	for _, r := range c.resolvers {
+		if r != last {
+			ordered = append(ordered, r)
+		}
+	}
+	return ordered
+}
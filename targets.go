@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SRVTarget holds the structured fields needed to build an SRV record's target string, per
+// the OVH zone record format (e.g. "10 5 5060 sip.example.com.").
+type SRVTarget struct {
+	Priority int    `yaml:"priority"`
+	Weight   int    `yaml:"weight"`
+	Port     int    `yaml:"port"`
+	Target   string `yaml:"target"`
+}
+
+func (t SRVTarget) build() string {
+	return fmt.Sprintf("%d %d %d %s", t.Priority, t.Weight, t.Port, t.Target)
+}
+
+// TLSATarget holds the structured fields needed to build a TLSA record's target string, per
+// the OVH zone record format (e.g. "3 1 1 <hex certificate association data>").
+type TLSATarget struct {
+	Usage        int    `yaml:"usage"`
+	Selector     int    `yaml:"selector"`
+	MatchingType int    `yaml:"matchingType"`
+	Certificate  string `yaml:"certificate"`
+}
+
+func (t TLSATarget) build() string {
+	return fmt.Sprintf("%d %d %d %s", t.Usage, t.Selector, t.MatchingType, t.Certificate)
+}
+
+// SSHFPTarget holds the structured fields needed to build an SSHFP record's target string,
+// per the OVH zone record format (e.g. "1 1 <hex fingerprint>").
+type SSHFPTarget struct {
+	Algorithm   int    `yaml:"algorithm"`
+	FPType      int    `yaml:"fpType"`
+	Fingerprint string `yaml:"fingerprint"`
+}
+
+func (t SSHFPTarget) build() string {
+	return fmt.Sprintf("%d %d %s", t.Algorithm, t.FPType, t.Fingerprint)
+}
+
+// ResolveTargetForType returns the desired target value for fieldType as configured on e:
+// for A/AAAA, either the static targetSource value or the current public IP for the address
+// family declared by targetSource ("public-v4" or "public-v6", which Validate already
+// requires to agree with fieldType); the static targetSource value for CNAME/TXT/CAA; and the
+// assembled target string for the structured types SRV/TLSA/SSHFP.
+func (e Entry) ResolveTargetForType(fieldType string) (string, error) {
+	switch fieldType {
+	case "A", "AAAA":
+		if strings.HasPrefix(e.TargetSource, staticTargetPrefix) {
+			return staticTarget(e.TargetSource)
+		}
+		switch e.TargetSource {
+		case "public-v4":
+			return getPublicIP(false)
+		case "public-v6":
+			return getPublicIP(true)
+		default:
+			return "", fmt.Errorf("entry %q: unsupported targetSource %q for fieldType %s", e.Subdomain, e.TargetSource, fieldType)
+		}
+	case "CNAME", "TXT", "CAA":
+		return staticTarget(e.TargetSource)
+	case "SRV":
+		if e.SRV == nil {
+			return "", fmt.Errorf("entry %q: fieldType SRV requires an srv block", e.Subdomain)
+		}
+		return e.SRV.build(), nil
+	case "TLSA":
+		if e.TLSA == nil {
+			return "", fmt.Errorf("entry %q: fieldType TLSA requires a tlsa block", e.Subdomain)
+		}
+		return e.TLSA.build(), nil
+	case "SSHFP":
+		if e.SSHFP == nil {
+			return "", fmt.Errorf("entry %q: fieldType SSHFP requires an sshfp block", e.Subdomain)
+		}
+		return e.SSHFP.build(), nil
+	default:
+		return "", fmt.Errorf("unsupported fieldType %q", fieldType)
+	}
+}
+
+// staticTarget strips the "static:" prefix required of targetSource for record types whose
+// target isn't derived from the host's public IP.
+func staticTarget(source string) (string, error) {
+	if !strings.HasPrefix(source, staticTargetPrefix) {
+		return "", fmt.Errorf("targetSource %q must use the %q prefix for this record type", source, staticTargetPrefix)
+	}
+	return strings.TrimPrefix(source, staticTargetPrefix), nil
+}